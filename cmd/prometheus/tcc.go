@@ -5,57 +5,156 @@ import (
 	"context"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"time"
 
-	"k8s.io/apimachinery/pkg/util/wait"
-
 	"code.byted.org/gopkg/tccclient"
+
+	"github.com/prometheus/prometheus/scrape"
 )
 
+// tccReloadSource polls a TCC key and, whenever its value changes,
+// writes the new value to filePath and sends it on the channel passed to
+// Run. It implements scrape.ReloadSource so that TCC is just one of
+// potentially several pluggable external config feeds.
+type tccReloadSource struct {
+	client   tccclient.Client
+	key      string
+	filePath string
+	interval time.Duration
+}
+
+func newTccReloadSource(psm, key, filePath string) (*tccReloadSource, error) {
+	client, err := tccclient.NewClientV2(psm, tccclient.NewConfigV2())
+	if err != nil {
+		return nil, err
+	}
+	return &tccReloadSource{
+		client:   client,
+		key:      key,
+		filePath: filePath,
+		interval: 15 * time.Second,
+	}, nil
+}
+
+// Run implements scrape.ReloadSource.
+func (s *tccReloadSource) Run(ctx context.Context, changed chan<- []byte) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.poll(ctx, changed)
+		}
+	}
+}
+
+func (s *tccReloadSource) poll(ctx context.Context, changed chan<- []byte) {
+	data, err := ioutil.ReadFile(s.filePath)
+	if err != nil {
+		log.Println("read config file error", err)
+		return
+	}
+	tccData, err := s.client.Get(ctx, s.key)
+	if err != nil {
+		log.Println("get tcc config error", err)
+		return
+	}
+	tccDataByte := []byte(tccData)
+	if bytes.EqualFold(data, tccDataByte) {
+		return
+	}
+
+	newFile, err := os.OpenFile(s.filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Println("open config file error", err)
+		return
+	}
+	defer func() { _ = newFile.Close() }()
+	if _, err := newFile.Write(tccDataByte); err != nil {
+		log.Println("write config file error", err)
+		return
+	}
+	changed <- tccDataByte
+}
+
+// TccHandler runs a tccReloadSource in the background, applies any
+// bosun_configs block found in the reloaded bytes to a BosunManager, and
+// signals waitTccLoad callers whenever the watched config file has been
+// rewritten from TCC.
 type TccHandler struct {
 	configChanged chan bool
+	bosun         *scrape.BosunManager
 }
 
+var _ scrape.ReloadSource = (*tccReloadSource)(nil)
+
+// bosunScrapeInterval is how often NewTccHandler's BosunManager scrapes
+// every job it has configured, so that Series results are actually
+// retained for SeriesHandler to read back.
+const bosunScrapeInterval = 30 * time.Second
+
+// NewTccHandler builds a TccHandler and starts its background reload
+// and scrape loops. It does not touch any ServeMux; call
+// RegisterBosunHandlers once, separately, to mount its debug endpoints.
 func NewTccHandler(psm, key, filePath string) *TccHandler {
-	c := &TccHandler{configChanged: make(chan bool)}
-	client, err := tccclient.NewClientV2(psm, tccclient.NewConfigV2())
+	c := &TccHandler{configChanged: make(chan bool), bosun: scrape.NewBosunManager(nil)}
+	go c.bosun.Run(context.Background(), bosunScrapeInterval)
+
+	source, err := newTccReloadSource(psm, key, filePath)
 	if err != nil {
 		log.Println("new tcc client failed", err)
 		return c
 	}
-	go wait.Forever(func() {
-		data, err := ioutil.ReadFile(filePath)
-		if err != nil {
-			log.Println("read config file error", err)
-			return
-		}
-		tccData, err := client.Get(context.Background(), key)
-		if err != nil {
-			log.Println("get tcc config error", err)
-			return
-		}
-		tccDataByte := []byte(tccData)
-		if bytes.EqualFold(data, tccDataByte) {
-			return
-		}
-
-		newFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-		if err != nil {
-			log.Println("open config file error", err)
-			return
-		}
-		defer func() { _ = newFile.Close() }()
-		_, err = newFile.Write(tccDataByte)
-		if err != nil {
-			log.Println("write config file error", err)
-			return
+	changed := make(chan []byte)
+	go source.Run(context.Background(), changed)
+	go func() {
+		for data := range changed {
+			if err := c.applyBosunConfigs(data); err != nil {
+				log.Println("apply bosun configs from tcc failed", err)
+			}
+			c.configChanged <- true
 		}
-		c.configChanged <- true
-	}, time.Second*15)
+	}()
 	return c
 }
 
+// RegisterBosunHandlers mounts c's bosun debug endpoints - expression
+// evaluation and Series read-back - on mux. Call this once, wherever
+// the caller builds its real ServeMux; unlike the constructor, calling
+// it twice on two different muxes is fine, and calling it twice on the
+// same mux fails loudly at the call site instead of panicking inside
+// NewTccHandler.
+func (c *TccHandler) RegisterBosunHandlers(mux *http.ServeMux) {
+	mux.Handle("/api/v1/bosun/eval", c.bosun.EvalHandler())
+	mux.Handle("/api/v1/bosun/series", c.bosun.SeriesHandler())
+}
+
+// applyBosunConfigs decodes an auth_providers block and a bosun_configs
+// block out of data and, if present, pushes them into scrape's auth
+// provider registry and c.bosun respectively. This is the path from a
+// scrape_config delivered over TCC to a live bosunScraper:
+// LoadAuthProviders -> ConfigureAuthProvider makes `auth_mode: provider`
+// resolvable, then LoadBosunConfigs -> BosunManager.ApplyConfig ->
+// newBosunScraper starts the scraper itself.
+func (c *TccHandler) applyBosunConfigs(data []byte) error {
+	if err := scrape.LoadAuthProviders(data); err != nil {
+		return err
+	}
+
+	cfgs, err := scrape.LoadBosunConfigs(data)
+	if err != nil {
+		return err
+	}
+	if len(cfgs) == 0 {
+		return nil
+	}
+	return c.bosun.ApplyConfig(cfgs)
+}
+
 func (c *TccHandler) waitTccLoad(timeout time.Duration) {
 	select {
 	case <-time.After(timeout):
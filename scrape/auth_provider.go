@@ -0,0 +1,121 @@
+package scrape
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// AuthProvider supplies the Authorization header value for scrapers that
+// use BosunAuthProvider (or an equivalent provider-backed auth mode).
+// Implementations are responsible for their own caching; AccessToken may
+// be called once per scrape.
+type AuthProvider interface {
+	// AccessToken returns the current Authorization header value and the
+	// time at which it expires. A zero expiry means the token does not
+	// expire.
+	AccessToken(ctx context.Context) (string, time.Time, error)
+	// Refresh forces the provider to obtain a new token ahead of its
+	// normal refresh schedule.
+	Refresh(ctx context.Context) error
+}
+
+// AuthProviderFactory builds an AuthProvider from its YAML configuration.
+// cfg is the raw `auth_providers.<name>.config` block, decoded into
+// whatever type the factory expects.
+type AuthProviderFactory func(cfg map[string]interface{}) (AuthProvider, error)
+
+var (
+	authProviderFactoriesMu sync.Mutex
+	authProviderFactories   = map[string]AuthProviderFactory{}
+
+	authProvidersMu sync.RWMutex
+	authProviders   = map[string]AuthProvider{}
+)
+
+// RegisterAuthProviderFactory makes an auth provider backend available
+// under name, so that it can be instantiated from YAML without scraper
+// code knowing about it. Intended to be called from an init() function
+// in the package implementing the backend.
+func RegisterAuthProviderFactory(name string, factory AuthProviderFactory) {
+	authProviderFactoriesMu.Lock()
+	defer authProviderFactoriesMu.Unlock()
+	authProviderFactories[name] = factory
+}
+
+// ConfigureAuthProvider instantiates the auth provider backend named
+// kind and makes it available under name for bosun_configs'
+// `auth_provider` field to reference.
+func ConfigureAuthProvider(name, kind string, cfg map[string]interface{}) error {
+	authProviderFactoriesMu.Lock()
+	factory, ok := authProviderFactories[kind]
+	authProviderFactoriesMu.Unlock()
+	if !ok {
+		return errors.Errorf("scrape: unknown auth provider backend %q", kind)
+	}
+	provider, err := factory(cfg)
+	if err != nil {
+		return errors.Wrapf(err, "scrape: configuring auth provider %q", name)
+	}
+	authProvidersMu.Lock()
+	authProviders[name] = provider
+	authProvidersMu.Unlock()
+	return nil
+}
+
+// authProviderConfig is one entry of an `auth_providers` YAML block:
+//
+//	auth_providers:
+//	  my-provider:
+//	    kind: mq-cluster-token
+//	    config:
+//	      cluster: cn
+//	      tenant: ad.oe.metrics
+//	      secret: "..."
+type authProviderConfig struct {
+	Kind   string                 `yaml:"kind"`
+	Config map[string]interface{} `yaml:"config"`
+}
+
+// LoadAuthProviders decodes an `auth_providers` block out of raw YAML
+// bytes and calls ConfigureAuthProvider for each entry, so that
+// bosun_configs' `auth_mode: provider` targets can resolve a named
+// provider from config alone.
+func LoadAuthProviders(data []byte) error {
+	var raw struct {
+		AuthProviders map[string]authProviderConfig `yaml:"auth_providers"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return errors.Wrap(err, "auth_providers: invalid YAML")
+	}
+	for name, cfg := range raw.AuthProviders {
+		if err := ConfigureAuthProvider(name, cfg.Kind, cfg.Config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAuthProvider looks up an auth provider previously registered via
+// ConfigureAuthProvider.
+func getAuthProvider(name string) (AuthProvider, error) {
+	authProvidersMu.RLock()
+	defer authProvidersMu.RUnlock()
+	provider, ok := authProviders[name]
+	if !ok {
+		return nil, errors.Errorf("scrape: auth provider %q is not configured", name)
+	}
+	return provider, nil
+}
+
+// ReloadSource is an external config feed that can push new raw config
+// bytes to Prometheus outside of its normal SIGHUP/file-watch reload
+// path, e.g. a KV store or HTTP endpoint polled on an interval.
+type ReloadSource interface {
+	// Run starts the source; it blocks until ctx is canceled. Each time
+	// new config bytes are available, Run sends them on changed.
+	Run(ctx context.Context, changed chan<- []byte)
+}
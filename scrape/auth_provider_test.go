@@ -0,0 +1,88 @@
+package scrape
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fakeAuthProvider struct {
+	token string
+}
+
+func (p *fakeAuthProvider) AccessToken(ctx context.Context) (string, time.Time, error) {
+	return p.token, time.Time{}, nil
+}
+
+func (p *fakeAuthProvider) Refresh(ctx context.Context) error { return nil }
+
+func TestConfigureAndGetAuthProvider(t *testing.T) {
+	RegisterAuthProviderFactory("fake-test-provider", func(cfg map[string]interface{}) (AuthProvider, error) {
+		token, _ := cfg["token"].(string)
+		return &fakeAuthProvider{token: token}, nil
+	})
+
+	if err := ConfigureAuthProvider("my-provider", "fake-test-provider", map[string]interface{}{"token": "Bearer abc"}); err != nil {
+		t.Fatalf("ConfigureAuthProvider: %v", err)
+	}
+
+	provider, err := getAuthProvider("my-provider")
+	if err != nil {
+		t.Fatalf("getAuthProvider: %v", err)
+	}
+	token, _, err := provider.AccessToken(context.Background())
+	if err != nil {
+		t.Fatalf("AccessToken: %v", err)
+	}
+	if token != "Bearer abc" {
+		t.Fatalf("AccessToken() = %q, want %q", token, "Bearer abc")
+	}
+}
+
+func TestGetAuthProviderUnconfigured(t *testing.T) {
+	if _, err := getAuthProvider("does-not-exist"); err == nil {
+		t.Fatal("getAuthProvider(unconfigured name): want error, got nil")
+	}
+}
+
+func TestConfigureAuthProviderUnknownKind(t *testing.T) {
+	if err := ConfigureAuthProvider("my-provider", "does-not-exist-kind", nil); err == nil {
+		t.Fatal("ConfigureAuthProvider(unknown kind): want error, got nil")
+	}
+}
+
+func TestLoadAuthProviders(t *testing.T) {
+	RegisterAuthProviderFactory("fake-test-provider-2", func(cfg map[string]interface{}) (AuthProvider, error) {
+		token, _ := cfg["token"].(string)
+		return &fakeAuthProvider{token: token}, nil
+	})
+
+	data := []byte(`
+auth_providers:
+  from-yaml:
+    kind: fake-test-provider-2
+    config:
+      token: "Bearer xyz"
+`)
+	if err := LoadAuthProviders(data); err != nil {
+		t.Fatalf("LoadAuthProviders: %v", err)
+	}
+
+	provider, err := getAuthProvider("from-yaml")
+	if err != nil {
+		t.Fatalf("getAuthProvider: %v", err)
+	}
+	token, _, err := provider.AccessToken(context.Background())
+	if err != nil {
+		t.Fatalf("AccessToken: %v", err)
+	}
+	if token != "Bearer xyz" {
+		t.Fatalf("AccessToken() = %q, want %q", token, "Bearer xyz")
+	}
+}
+
+func TestLoadAuthProvidersEmpty(t *testing.T) {
+	if err := LoadAuthProviders([]byte(`bosun_configs: {}`)); err != nil {
+		t.Fatalf("LoadAuthProviders with no auth_providers block: %v", err)
+	}
+}
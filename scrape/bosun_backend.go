@@ -0,0 +1,56 @@
+package scrape
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// bosunBackend implements Backend for the q(...) leaves of an
+// expression tree by replaying the OpenTSDB query against the same
+// endpoint the scraper's Rule would otherwise be POSTed to.
+type bosunBackend struct {
+	scraper *bosunScraper
+}
+
+func (b *bosunBackend) Query(ctx context.Context, q *Query, start, end string) (Results, error) {
+	body, err := json.Marshal(Request{
+		Start:   start,
+		End:     end,
+		Queries: []*Query{q},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	url := b.scraper.cfg.URL
+	if b.scraper.cfg.Region != "" {
+		url += "?_region=" + b.scraper.cfg.Region
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := b.scraper.setAuth(ctx, req); err != nil {
+		return nil, err
+	}
+
+	resp, err := b.scraper.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("bosun backend: server returned HTTP status %s", resp.Status)
+	}
+
+	var out BosunResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return Results(out.Results), nil
+}
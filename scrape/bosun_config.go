@@ -0,0 +1,166 @@
+package scrape
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BosunAuthMode selects how a bosun_configs target authenticates its
+// requests to the expression endpoint.
+type BosunAuthMode string
+
+const (
+	// BosunAuthNone sends no Authorization header at all.
+	BosunAuthNone BosunAuthMode = "none"
+	// BosunAuthBasic sends HTTP basic auth credentials from BasicAuth.
+	BosunAuthBasic BosunAuthMode = "basic"
+	// BosunAuthBearer sends a static bearer token as the Authorization
+	// header.
+	BosunAuthBearer BosunAuthMode = "bearer"
+	// BosunAuthProvider obtains the Authorization header value from a
+	// named AuthProvider, e.g. a bearer/token-refresher plugin.
+	BosunAuthProvider BosunAuthMode = "provider"
+)
+
+// BosunBasicAuth holds static basic-auth credentials for a bosun target.
+type BosunBasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// BosunLabelMapping renames a Bosun Result TagSet key to a Prometheus
+// label name. Tag keys that are not listed keep their original name.
+type BosunLabelMapping struct {
+	TagKey string `yaml:"tag_key"`
+	Label  string `yaml:"label"`
+}
+
+// BosunMetricType hints at how Bosun series results should be typed
+// when rendered as Prometheus samples.
+type BosunMetricType string
+
+const (
+	BosunMetricUntyped BosunMetricType = "untyped"
+	BosunMetricGauge   BosunMetricType = "gauge"
+	BosunMetricCounter BosunMetricType = "counter"
+)
+
+// BosunConfig is the per-target configuration for the bosun scrape
+// protocol. It is read, keyed by job name, from a top-level
+// `bosun_configs` block by LoadBosunConfigs; it is not part of a
+// scrape_config, and a target cannot select it via a scrape_protocol
+// setting.
+type BosunConfig struct {
+	// URL is the Bosun/expression endpoint, e.g.
+	// "http://bosun.example.org/api/expr".
+	URL string `yaml:"url"`
+	// Region is passed through as the "_region" query parameter.
+	Region string `yaml:"region,omitempty"`
+	// ScrapeTimeout bounds each request to URL. Zero means the caller
+	// (e.g. BosunManager) picks its own default.
+	ScrapeTimeout time.Duration `yaml:"scrape_timeout,omitempty"`
+
+	AuthMode     BosunAuthMode   `yaml:"auth_mode,omitempty"`
+	BasicAuth    *BosunBasicAuth `yaml:"basic_auth,omitempty"`
+	BearerToken  string          `yaml:"bearer_token,omitempty"`
+	AuthProvider string          `yaml:"auth_provider,omitempty"`
+
+	// Rule is the raw Bosun expression evaluated for this target. It is
+	// ignored when Expr is set.
+	Rule string `yaml:"rule"`
+	// Expr is a small Bosun-style expression (see expr.go) evaluated
+	// against Queries/Backend instead of Rule. When set, it takes
+	// precedence over Rule.
+	Expr string `yaml:"expr,omitempty"`
+	// MetricName is the name of the metric family produced from Rule's
+	// results. It may reference capture groups from Rule in the future;
+	// today it is used verbatim.
+	MetricName string          `yaml:"metric_name"`
+	MetricType BosunMetricType `yaml:"metric_type,omitempty"`
+
+	// LabelMappings renames Bosun TagSet keys to Prometheus label names.
+	LabelMappings []BosunLabelMapping `yaml:"label_mappings,omitempty"`
+
+	// Retries is the number of additional attempts made if the request
+	// to URL fails or returns a status other than 200 OK.
+	Retries int `yaml:"retries,omitempty"`
+
+	// MaxLookback discards Series points older than this relative to
+	// the scrape time. Zero disables the check.
+	MaxLookback time.Duration `yaml:"max_lookback,omitempty"`
+	// OutOfOrderTolerance allows a Series point to be appended even if
+	// it arrives up to this long after a newer point for the same
+	// series was already ingested, instead of being dropped as a
+	// duplicate.
+	OutOfOrderTolerance time.Duration `yaml:"out_of_order_tolerance,omitempty"`
+	// StaleMarkers synthesizes a stale marker sample for any series
+	// that was present in the previous scrape but is absent from this
+	// one.
+	StaleMarkers bool `yaml:"stale_markers,omitempty"`
+}
+
+// Validate fills in defaults and rejects incomplete configs.
+func (c *BosunConfig) Validate() error {
+	if c.URL == "" {
+		return errors.New("bosun_configs: url must not be empty")
+	}
+	if c.MetricName == "" {
+		return errors.New("bosun_configs: metric_name must not be empty")
+	}
+	if c.Rule == "" && c.Expr == "" {
+		return errors.New("bosun_configs: one of rule or expr must be set")
+	}
+	if c.Expr != "" {
+		if _, err := Parse(c.Expr); err != nil {
+			return errors.Wrap(err, "bosun_configs: invalid expr")
+		}
+	}
+	if c.AuthMode == "" {
+		c.AuthMode = BosunAuthNone
+	}
+	if c.MetricType == "" {
+		c.MetricType = BosunMetricUntyped
+	}
+	if c.AuthMode == BosunAuthBearer && c.BearerToken == "" {
+		return errors.New("bosun_configs: bearer_token must be set when auth_mode is \"bearer\"")
+	}
+	if c.AuthMode == BosunAuthBasic && c.BasicAuth == nil {
+		return errors.New("bosun_configs: basic_auth must be set when auth_mode is \"basic\"")
+	}
+	if c.AuthMode == BosunAuthProvider && c.AuthProvider == "" {
+		return errors.New("bosun_configs: auth_provider must be set when auth_mode is \"provider\"")
+	}
+	return nil
+}
+
+// labelName returns the Prometheus label name for a Bosun TagSet key,
+// applying LabelMappings where one is configured.
+func (c *BosunConfig) labelName(tagKey string) string {
+	for _, m := range c.LabelMappings {
+		if m.TagKey == tagKey {
+			return m.Label
+		}
+	}
+	return tagKey
+}
+
+// newBosunScraper builds a bosunScraper for t, reading its scrape
+// parameters from cfg and bounding each request by timeout. appender, if
+// non-nil, receives every Series result's samples instead of having
+// them folded into the text-exposition fallback; pass nil to keep the
+// old single-family behavior.
+func newBosunScraper(t *Target, cfg *BosunConfig, client *http.Client, timeout time.Duration, appender SeriesAppender) *bosunScraper {
+	deadline := newScrapeDeadline()
+	return &bosunScraper{
+		Target:          t,
+		client:          wrapClientWithDeadline(client, deadline),
+		timeout:         timeout,
+		cfg:             cfg,
+		series:          newSeriesState(),
+		appender:        appender,
+		defaultAppender: newMetricFamilyAppender(cfg),
+		deadline:        deadline,
+	}
+}
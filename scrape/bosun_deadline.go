@@ -0,0 +1,159 @@
+package scrape
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var scrapeTimeoutPhase = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "scrape_timeout_phase_total",
+		Help: "Total number of bosun scrape timeouts, broken down by the phase in which they occurred.",
+	},
+	[]string{"phase"},
+)
+
+func init() {
+	prometheus.MustRegister(scrapeTimeoutPhase)
+}
+
+// scrapeDeadline holds the single absolute wall-clock deadline a
+// bosunScraper's in-flight request must finish by, covering connect,
+// request write, and response read alike. Rather than racing a
+// detached goroutine against a timer, it is handed to deadlineTransport
+// so the deadline is enforced by the underlying net.Conn itself via
+// SetDeadline - the same mechanism any well-behaved Go client uses.
+type scrapeDeadline struct {
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func newScrapeDeadline() *scrapeDeadline {
+	return &scrapeDeadline{}
+}
+
+// SetDeadline arms the deadline for the next request. Passing the zero
+// time disarms it.
+func (d *scrapeDeadline) SetDeadline(t time.Time) {
+	d.mu.Lock()
+	d.deadline = t
+	d.mu.Unlock()
+}
+
+func (d *scrapeDeadline) current() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.deadline
+}
+
+// Deadline reports whether the current deadline has already elapsed, so
+// a streaming decoder (json.Decoder) can poll it between tokens instead
+// of discovering a hung body only once a Read call finally returns.
+func (d *scrapeDeadline) Deadline() bool {
+	t := d.current()
+	return !t.IsZero() && time.Now().After(t)
+}
+
+// deadlineTransport is an http.RoundTripper that enforces scrapeDeadline
+// by bounding the dial with it and then setting it as the net.Conn's own
+// deadline, covering connect, request write, and response read with one
+// OS-enforced timeout instead of a timer racing a detached goroutine.
+// Reads and writes that time out are attributed to a "connect" or
+// "body" phase via scrapeTimeoutPhase so the two are distinguishable.
+type deadlineTransport struct {
+	base *http.Transport
+}
+
+// newDeadlineTransport wraps base so every connection it dials has
+// scrapeDeadline's current deadline applied to it. base is taken over
+// by the returned transport; callers should not mutate it afterwards.
+func newDeadlineTransport(base *http.Transport, deadline *scrapeDeadline) *deadlineTransport {
+	dial := base.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	base.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		t := deadline.current()
+		if !t.IsZero() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, t)
+			defer cancel()
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if !t.IsZero() {
+			conn.SetDeadline(t)
+		}
+		return &deadlineConn{Conn: conn}, nil
+	}
+	return &deadlineTransport{base: base}
+}
+
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req)
+}
+
+// deadlineConn labels which phase a deadline-exceeded error surfaced
+// in: Go's net.Conn just reports a plain timeout, with no notion of
+// "this was still the request write" versus "this was the response
+// body".
+type deadlineConn struct {
+	net.Conn
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if isTimeout(err) {
+		scrapeTimeoutPhase.WithLabelValues("connect").Inc()
+	}
+	return n, err
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if isTimeout(err) {
+		scrapeTimeoutPhase.WithLabelValues("body").Inc()
+	}
+	return n, err
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// wrapClientWithDeadline returns a shallow copy of base whose Transport
+// enforces deadline on every connection it dials, cloning base's
+// *http.Transport (or http.DefaultTransport, if base has none) so its
+// other settings - proxy, TLS config - are kept.
+//
+// Keep-alives are disabled on the clone: deadline.current() is only
+// read once, in DialContext, when a connection is first dialed. A
+// pooled, reused connection would otherwise keep running under whatever
+// scrape's deadline happened to be live at dial time forever, instead
+// of the deadline set for the scrape actually using it. Disabling
+// keep-alives guarantees every request dials (and deadlines) its own
+// connection.
+func wrapClientWithDeadline(base *http.Client, deadline *scrapeDeadline) *http.Client {
+	if base == nil {
+		base = http.DefaultClient
+	}
+	client := *base
+
+	var transport *http.Transport
+	if t, ok := client.Transport.(*http.Transport); ok && t != nil {
+		transport = t.Clone()
+	} else {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	transport.DisableKeepAlives = true
+	client.Transport = newDeadlineTransport(transport, deadline)
+	return &client
+}
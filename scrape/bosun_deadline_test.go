@@ -0,0 +1,132 @@
+package scrape
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestScrapeDeadline(t *testing.T) {
+	d := newScrapeDeadline()
+	if d.Deadline() {
+		t.Fatal("Deadline() with no deadline set: want false")
+	}
+
+	d.SetDeadline(time.Now().Add(-time.Second))
+	if !d.Deadline() {
+		t.Fatal("Deadline() past a deadline in the past: want true")
+	}
+
+	d.SetDeadline(time.Now().Add(time.Hour))
+	if d.Deadline() {
+		t.Fatal("Deadline() before a future deadline: want false")
+	}
+
+	d.SetDeadline(time.Time{})
+	if d.Deadline() {
+		t.Fatal("Deadline() after clearing: want false")
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTimeout(t *testing.T) {
+	if isTimeout(nil) {
+		t.Fatal("isTimeout(nil): want false")
+	}
+	if isTimeout(errors.New("boom")) {
+		t.Fatal("isTimeout(plain error): want false")
+	}
+	if !isTimeout(timeoutError{}) {
+		t.Fatal("isTimeout(net.Error with Timeout()==true): want true")
+	}
+}
+
+func TestDeadlineConnCountsBodyTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	deadline := newScrapeDeadline()
+	deadline.SetDeadline(time.Now().Add(20 * time.Millisecond))
+	conn := &deadlineConn{Conn: client}
+	if err := conn.Conn.SetDeadline(deadline.current()); err != nil {
+		t.Fatalf("SetDeadline: %v", err)
+	}
+
+	// Nothing is ever written by the server end of the pipe, so Read
+	// should block until the deadline set above elapses and then return
+	// a timeout error, with the phase recorded as "body".
+	buf := make([]byte, 1)
+	before := counterValue(t, "body")
+	_, err := conn.Read(buf)
+	if !isTimeout(err) {
+		t.Fatalf("Read past deadline: want a timeout error, got %v", err)
+	}
+	if after := counterValue(t, "body"); after != before+1 {
+		t.Fatalf("scrapeTimeoutPhase{phase=body} = %v, want %v", after, before+1)
+	}
+}
+
+func TestWrapClientWithDeadlineDisablesKeepAlives(t *testing.T) {
+	client := wrapClientWithDeadline(nil, newScrapeDeadline())
+	dt, ok := client.Transport.(*deadlineTransport)
+	if !ok {
+		t.Fatalf("client.Transport = %T, want *deadlineTransport", client.Transport)
+	}
+	if !dt.base.DisableKeepAlives {
+		t.Fatal("want DisableKeepAlives set, so every request dials (and deadlines) a fresh connection")
+	}
+}
+
+func TestWrapClientWithDeadlineRefreshesPerRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(2 * time.Second)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	deadline := newScrapeDeadline()
+	client := wrapClientWithDeadline(http.DefaultClient, deadline)
+
+	// A first request under a generous deadline completes normally.
+	deadline.SetDeadline(time.Now().Add(time.Minute))
+	resp, err := client.Get(srv.URL + "/fast")
+	if err != nil {
+		t.Fatalf("fast request: %v", err)
+	}
+	resp.Body.Close()
+
+	// A second request, configured with a much tighter deadline, must be
+	// cut off close to its own deadline rather than running under the
+	// first request's minute-long one - which it would if the
+	// connection were reused and only deadlined once at dial time.
+	deadline.SetDeadline(time.Now().Add(100 * time.Millisecond))
+	start := time.Now()
+	if _, err := client.Get(srv.URL + "/slow"); err == nil {
+		t.Fatal("slow request: want a timeout error, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("slow request took %v, want it cut off near its 100ms deadline, not the earlier minute-long one", elapsed)
+	}
+}
+
+func counterValue(t *testing.T, phase string) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := scrapeTimeoutPhase.WithLabelValues(phase).Write(&m); err != nil {
+		t.Fatalf("reading counter: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
@@ -0,0 +1,36 @@
+package scrape
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// BosunEvalHandler returns a debug endpoint for one-off expression
+// evaluation: it parses the "expr" query parameter with Parse and
+// evaluates it against backend, responding with the resulting Results
+// as JSON. It is intended to be mounted at /api/v1/bosun/eval alongside
+// Prometheus' other debug endpoints.
+func BosunEvalHandler(backend Backend) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawExpr := r.URL.Query().Get("expr")
+		if rawExpr == "" {
+			http.Error(w, `missing "expr" query parameter`, http.StatusBadRequest)
+			return
+		}
+		tree, err := Parse(rawExpr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		results, err := tree.Eval(r.Context(), backend)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(results); err != nil {
+			log.Println("bosun eval handler: encoding response failed", err)
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// BosunManager owns the set of live bosunScrapers configured via
+// bosun_configs, keyed by job name. It is what actually turns a
+// BosunConfig into a running scraper: ApplyConfig is the sole caller of
+// newBosunScraper and BosunConfig.Validate in this package. A
+// ReloadSource (e.g. tccReloadSource in cmd/prometheus) feeds it new
+// config bytes via LoadBosunConfigs whenever the underlying source
+// changes.
+type BosunManager struct {
+	mu        sync.Mutex
+	client    *http.Client
+	scrapers  map[string]*bosunScraper
+	appenders map[string]*memSeriesAppender
+}
+
+// NewBosunManager builds an empty BosunManager. client is used for every
+// scraper's requests; a nil client falls back to http.DefaultClient.
+func NewBosunManager(client *http.Client) *BosunManager {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &BosunManager{
+		client:    client,
+		scrapers:  map[string]*bosunScraper{},
+		appenders: map[string]*memSeriesAppender{},
+	}
+}
+
+// LoadBosunConfigs decodes the top-level `bosun_configs` block - job
+// name to BosunConfig - out of raw YAML bytes, e.g. the contents of a
+// reloaded config file or TCC value. Other top-level keys are ignored; a
+// document without the block yields no configs.
+func LoadBosunConfigs(data []byte) (map[string]*BosunConfig, error) {
+	var raw struct {
+		BosunConfigs map[string]*BosunConfig `yaml:"bosun_configs"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Wrap(err, "bosun_configs: invalid YAML")
+	}
+	return raw.BosunConfigs, nil
+}
+
+// ApplyConfig validates cfgs and replaces the manager's set of running
+// scrapers with one bosunScraper per job.
+func (m *BosunManager) ApplyConfig(cfgs map[string]*BosunConfig) error {
+	scrapers := make(map[string]*bosunScraper, len(cfgs))
+	appenders := make(map[string]*memSeriesAppender, len(cfgs))
+	for job, cfg := range cfgs {
+		if err := cfg.Validate(); err != nil {
+			return errors.Wrapf(err, "bosun_configs[%q]", job)
+		}
+		timeout := cfg.ScrapeTimeout
+		if timeout <= 0 {
+			timeout = 10 * time.Second
+		}
+		appender := newMemSeriesAppender()
+		appenders[job] = appender
+		scrapers[job] = newBosunScraper(&Target{}, cfg, m.client, timeout, appender)
+	}
+
+	m.mu.Lock()
+	m.scrapers = scrapers
+	m.appenders = appenders
+	m.mu.Unlock()
+	return nil
+}
+
+// Series returns the samples ingested so far for group on job's
+// scraper, reading straight from the memSeriesAppender wired into it by
+// ApplyConfig.
+func (m *BosunManager) Series(job string, group TagSet) ([]Sample, error) {
+	m.mu.Lock()
+	appender, ok := m.appenders[job]
+	m.mu.Unlock()
+	if !ok {
+		return nil, errors.Errorf("bosun_configs: unknown job %q", job)
+	}
+	return appender.Samples(group), nil
+}
+
+// Scrape runs the named job's scraper and writes its exposition-format
+// output to w, same as the scrape loop would for any other target.
+func (m *BosunManager) Scrape(ctx context.Context, job string, w io.Writer) (string, error) {
+	s, err := m.scraper(job)
+	if err != nil {
+		return "", err
+	}
+	return s.scrape(ctx, w)
+}
+
+func (m *BosunManager) scraper(job string) (*bosunScraper, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.scrapers[job]
+	if !ok {
+		return nil, errors.Errorf("bosun_configs: unknown job %q", job)
+	}
+	return s, nil
+}
+
+func (m *BosunManager) jobNames() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]string, 0, len(m.scrapers))
+	for job := range m.scrapers {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// Run scrapes every configured job once per interval, discarding the
+// exposition-format output itself and blocking until ctx is canceled.
+// Each scrape drives the same render path a real scrape loop would,
+// which is what actually pushes Series results into the
+// memSeriesAppender ApplyConfig wired into that job - Series and
+// SeriesHandler have nothing to read back until this runs.
+func (m *BosunManager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.scrapeAll(ctx)
+		}
+	}
+}
+
+func (m *BosunManager) scrapeAll(ctx context.Context) {
+	for _, job := range m.jobNames() {
+		if _, err := m.Scrape(ctx, job, ioutil.Discard); err != nil {
+			log.Println("bosun_configs: scrape failed", "job", job, "err", err)
+		}
+	}
+}
+
+// parseTagSet parses a group's "k1=v1,k2=v2" form (as produced by
+// TagSet.Tags) back into a TagSet. An empty string returns an empty,
+// non-nil TagSet.
+func parseTagSet(raw string) (TagSet, error) {
+	group := TagSet{}
+	if raw == "" {
+		return group, nil
+	}
+	for _, pair := range strings.Split(raw, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, errors.Errorf("bosun_configs: invalid group tag %q, want k=v", pair)
+		}
+		group[kv[0]] = kv[1]
+	}
+	return group, nil
+}
+
+// SeriesHandler returns a debug endpoint reading back the samples
+// ingested for one job's series, selected by the "job" and "group"
+// (a TagSet.Tags() string, e.g. "host=a,dc=x") query parameters. Nothing
+// drives a job's scraper on its own; pair this with Run so there are
+// samples to read back.
+func (m *BosunManager) SeriesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		job := r.URL.Query().Get("job")
+		if job == "" {
+			http.Error(w, `missing "job" query parameter`, http.StatusBadRequest)
+			return
+		}
+		group, err := parseTagSet(r.URL.Query().Get("group"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		samples, err := m.Series(job, group)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(samples); err != nil {
+			log.Println("bosun series handler: encoding response failed", err)
+		}
+	})
+}
+
+// EvalHandler returns a debug endpoint for one-off expression
+// evaluation against any configured job's Backend, resolved from the
+// "job" query parameter. Mount it at /api/v1/bosun/eval alongside
+// Prometheus' other debug endpoints.
+func (m *BosunManager) EvalHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		job := r.URL.Query().Get("job")
+		if job == "" {
+			http.Error(w, `missing "job" query parameter`, http.StatusBadRequest)
+			return
+		}
+		s, err := m.scraper(job)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		BosunEvalHandler(&bosunBackend{scraper: s}).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,113 @@
+package scrape
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func numberConfig() *BosunConfig {
+	return &BosunConfig{
+		URL:        "http://unused.invalid",
+		MetricName: "test_metric",
+		Expr:       "1",
+	}
+}
+
+func TestBosunManagerApplyConfigAndScrape(t *testing.T) {
+	m := NewBosunManager(nil)
+	if err := m.ApplyConfig(map[string]*BosunConfig{"job-a": numberConfig()}); err != nil {
+		t.Fatalf("ApplyConfig: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := m.Scrape(context.Background(), "job-a", &buf); err != nil {
+		t.Fatalf("Scrape: %v", err)
+	}
+	if buf.Len() == 0 {
+		t.Fatal("Scrape: want non-empty exposition output, got none")
+	}
+
+	if _, err := m.Scrape(context.Background(), "unknown-job", &buf); err == nil {
+		t.Fatal("Scrape(unknown job): want error, got nil")
+	}
+}
+
+func TestBosunManagerScrapeAll(t *testing.T) {
+	m := NewBosunManager(nil)
+	if err := m.ApplyConfig(map[string]*BosunConfig{
+		"job-a": numberConfig(),
+		"job-b": numberConfig(),
+	}); err != nil {
+		t.Fatalf("ApplyConfig: %v", err)
+	}
+
+	// scrapeAll drives every configured job's scraper; a config that
+	// always succeeds should never log an error, and it shouldn't panic
+	// walking the job set either.
+	m.scrapeAll(context.Background())
+}
+
+func TestBosunManagerSeriesUnknownJob(t *testing.T) {
+	m := NewBosunManager(nil)
+	if _, err := m.Series("unknown-job", TagSet{}); err == nil {
+		t.Fatal("Series(unknown job): want error, got nil")
+	}
+}
+
+func TestParseTagSet(t *testing.T) {
+	got, err := parseTagSet("host=a,dc=x")
+	if err != nil {
+		t.Fatalf("parseTagSet: %v", err)
+	}
+	want := TagSet{"host": "a", "dc": "x"}
+	if !got.Equal(want) {
+		t.Fatalf("parseTagSet() = %+v, want %+v", got, want)
+	}
+
+	if got, err := parseTagSet(""); err != nil || len(got) != 0 {
+		t.Fatalf("parseTagSet(\"\") = %+v, %v, want empty TagSet, nil", got, err)
+	}
+
+	if _, err := parseTagSet("not-a-pair"); err == nil {
+		t.Fatal("parseTagSet(malformed): want error, got nil")
+	}
+}
+
+func TestBosunManagerSeriesHandler(t *testing.T) {
+	m := NewBosunManager(nil)
+	if err := m.ApplyConfig(map[string]*BosunConfig{"job-a": numberConfig()}); err != nil {
+		t.Fatalf("ApplyConfig: %v", err)
+	}
+
+	srv := httptest.NewServer(m.SeriesHandler())
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL + "?job=job-a&group=host%3Da")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	if resp, err := srv.Client().Get(srv.URL + "?group=host%3Da"); err != nil {
+		t.Fatalf("GET: %v", err)
+	} else {
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Fatalf("missing job: status = %d, want 400", resp.StatusCode)
+		}
+	}
+
+	if resp, err := srv.Client().Get(srv.URL + "?job=unknown-job"); err != nil {
+		t.Fatalf("GET: %v", err)
+	} else {
+		resp.Body.Close()
+		if resp.StatusCode != 404 {
+			t.Fatalf("unknown job: status = %d, want 404", resp.StatusCode)
+		}
+	}
+}
@@ -13,40 +13,13 @@ import (
 	"net/http"
 	"time"
 
-	mq "code.byted.org/inf/metrics-query"
 	"github.com/pkg/errors"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 )
 
-// https://doc.bytedance.net/docs/2080/2717/29482/
-
-var clusterOptionCN *mq.ClusterOption
-
-func init() {
-	// 集群（鉴权）配置，这里直接用预设好的配置。
-	clusterConfig := mq.DefaultClusterConfig.Proxy
-	// 集群配置也是一个 map ，键是集群字符串，值是鉴权选项。
-	clusterOptionCN = clusterConfig.Get("cn")
-	if clusterOptionCN != nil {
-		// 注意：必须设置注册好的查询账户，因为公共账号已经从流量上封死，总是返回 429 状态码。
-		clusterOptionCN.SetTenant("ad.oe.metrics", "bd00e4ed314f4fd5ad78865ad53fa290")
-		_, err := clusterOptionCN.RefreshToken(true, 10*time.Second)
-		if err != nil {
-			log.Println("RefreshToken failed, err: ", err)
-		}
-		go func() {
-			for range time.Tick(time.Second * 1800) {
-				_, err := clusterOptionCN.RefreshToken(false, 10*time.Second)
-				if err != nil {
-					log.Println("RefreshToken failed, err: ", err)
-				}
-			}
-		}()
-	}
-}
-
-// bosunScraper implements the scraper interface for a target.
+// bosunScraper implements the scraper interface for a target configured
+// with the bosun scrape protocol (see BosunConfig).
 type bosunScraper struct {
 	*Target
 
@@ -57,15 +30,47 @@ type bosunScraper struct {
 	gzipr *gzip.Reader
 	buf   *bufio.Reader
 
-	// added for bosun
-	rule        string
-	metricsName string
+	cfg    *BosunConfig
+	series *seriesState
+
+	// appender, if set, receives Series points instead of having them
+	// rendered through the text exposition path below. BosunManager
+	// wires a memSeriesAppender in here for every job it runs; a bare
+	// bosunScraper built without one falls back to defaultAppender.
+	appender        SeriesAppender
+	defaultAppender *metricFamilyAppender
+
+	exprTree Expr // parsed lazily from cfg.Expr, cached across scrapes
+
+	deadline *scrapeDeadline
 }
 
 func (s *bosunScraper) scrape(ctx context.Context, w io.Writer) (string, error) {
+	var lastErr error
+	for attempt := 0; attempt <= s.cfg.Retries; attempt++ {
+		contentType, err := s.scrapeOnce(ctx, w)
+		if err == nil {
+			return contentType, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return "", lastErr
+}
+
+func (s *bosunScraper) scrapeOnce(ctx context.Context, w io.Writer) (string, error) {
+	if s.cfg.Expr != "" {
+		return s.scrapeExpr(ctx, w)
+	}
 
-	req, err := http.NewRequest("POST",
-		"http://metrics.byted.org/proxy/bosun/api/expr?_region=cn", bytes.NewBufferString(s.rule))
+	url := s.cfg.URL
+	if s.cfg.Region != "" {
+		url += "?_region=" + s.cfg.Region
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBufferString(s.cfg.Rule))
 	if err != nil {
 		return "", err
 	}
@@ -73,11 +78,17 @@ func (s *bosunScraper) scrape(ctx context.Context, w io.Writer) (string, error)
 	req.Header.Set("User-Agent", userAgentHeader)
 	req.Header.Add("Accept-Encoding", "gzip")
 	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", fmt.Sprintf("%f", s.timeout.Seconds()))
-	req.Header.Set("Authorization", clusterOptionCN.AccessToken())
+
+	if err := s.setAuth(ctx, req); err != nil {
+		return "", err
+	}
 	s.req = req
 
+	if s.timeout > 0 {
+		s.deadline.SetDeadline(time.Now().Add(s.timeout))
+		defer s.deadline.SetDeadline(time.Time{})
+	}
 	resp, err := s.client.Do(s.req.WithContext(ctx))
-
 	if err != nil {
 		return "", err
 	}
@@ -87,12 +98,14 @@ func (s *bosunScraper) scrape(ctx context.Context, w io.Writer) (string, error)
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Println("request failed", s.req.Header.Get("Authorization"), "status", resp.Status)
+		log.Println("request failed", "url", s.req.URL, "status", resp.Status)
 		return "", errors.Errorf("server returned HTTP status %s", resp.Status)
 	}
 
+	body := resp.Body
+
 	if resp.Header.Get("Content-Encoding") != "gzip" {
-		err = s.convertCopy(w, resp.Body)
+		err = s.convertCopy(w, body)
 		if err != nil {
 			return "", err
 		}
@@ -100,13 +113,13 @@ func (s *bosunScraper) scrape(ctx context.Context, w io.Writer) (string, error)
 	}
 
 	if s.gzipr == nil {
-		s.buf = bufio.NewReader(resp.Body)
+		s.buf = bufio.NewReader(body)
 		s.gzipr, err = gzip.NewReader(s.buf)
 		if err != nil {
 			return "", err
 		}
 	} else {
-		s.buf.Reset(resp.Body)
+		s.buf.Reset(body)
 		if err = s.gzipr.Reset(s.buf); err != nil {
 			return "", err
 		}
@@ -120,58 +133,166 @@ func (s *bosunScraper) scrape(ctx context.Context, w io.Writer) (string, error)
 	return resp.Header.Get("Content-Type"), nil
 }
 
-// 重写返回为 prometheus 风格
+// setAuth populates the Authorization header of req according to the
+// target's configured auth mode.
+func (s *bosunScraper) setAuth(ctx context.Context, req *http.Request) error {
+	switch s.cfg.AuthMode {
+	case BosunAuthNone, "":
+		return nil
+	case BosunAuthBasic:
+		req.SetBasicAuth(s.cfg.BasicAuth.Username, s.cfg.BasicAuth.Password)
+		return nil
+	case BosunAuthBearer:
+		req.Header.Set("Authorization", "Bearer "+s.cfg.BearerToken)
+		return nil
+	case BosunAuthProvider:
+		provider, err := getAuthProvider(s.cfg.AuthProvider)
+		if err != nil {
+			return err
+		}
+		token, _, err := provider.AccessToken(ctx)
+		if err != nil {
+			return errors.Wrap(err, "obtaining bosun access token")
+		}
+		req.Header.Set("Authorization", token)
+		return nil
+	default:
+		return errors.Errorf("unknown bosun auth_mode %q", s.cfg.AuthMode)
+	}
+}
 
+// scrapeExpr evaluates cfg.Expr against a bosunBackend backed by this
+// scraper's own endpoint, instead of POSTing cfg.Rule.
+func (s *bosunScraper) scrapeExpr(ctx context.Context, w io.Writer) (string, error) {
+	if s.exprTree == nil {
+		tree, err := Parse(s.cfg.Expr)
+		if err != nil {
+			return "", err
+		}
+		s.exprTree = tree
+	}
+
+	results, err := s.exprTree.Eval(ctx, &bosunBackend{scraper: s})
+	if err != nil {
+		return "", err
+	}
+	if err := s.render(w, results); err != nil {
+		return "", err
+	}
+	return string(expfmt.FmtText), nil
+}
+
+// convertCopy decodes a BosunResponse and renders its Results.
 func (s *bosunScraper) convertCopy(writer io.Writer, reader io.Reader) error {
 	b := &BosunResponse{}
 	d := json.NewDecoder(reader)
 	if err := d.Decode(b); err != nil {
 		return err
 	}
-	t := dto.MetricType_UNTYPED
+	return s.render(writer, b.Results)
+}
+
+// render turns a set of Results into a single Prometheus metric family:
+// Number results become one sample each, Series results are
+// deduplicated against the previous scrape and pushed through the
+// configured SeriesAppender.
+func (s *bosunScraper) render(writer io.Writer, results []*Result) error {
+	now := time.Now()
+	seenNow := make(map[string]TagSet, len(results))
+	var metrics []*dto.Metric
+
+	for _, r := range results {
+		switch {
+		case r.Value.Number != nil:
+			v := *r.Value.Number
+			metrics = append(metrics, newBosunMetric(s.cfg.MetricType, convertTagSet2Labels(r.Group, s.cfg), v, nil))
+		case r.Value.Series != nil:
+			key := r.Group.Tags()
+			seenNow[key] = r.Group
+			samples := seriesSamples(*r.Value.Series)
+			samples = s.series.dedup(key, samples, s.cfg.MaxLookback, s.cfg.OutOfOrderTolerance, now)
+			if err := s.seriesAppender().AppendSeries(r.Group, samples); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.cfg.StaleMarkers {
+		staleTs := now.UnixNano() / int64(time.Millisecond)
+		for _, group := range s.series.observe(seenNow) {
+			metrics = append(metrics, newBosunMetric(s.cfg.MetricType, convertTagSet2Labels(group, s.cfg), staleNaN, &staleTs))
+		}
+	} else {
+		s.series.observe(seenNow)
+	}
+
+	metrics = append(metrics, s.defaultAppender.drain()...)
+
+	t := familyType(s.cfg.MetricType)
 	m := &dto.MetricFamily{
-		Name:   &s.metricsName,
+		Name:   &s.cfg.MetricName,
 		Type:   &t,
-		Metric: convertBosunResponse2Metrics(b),
+		Metric: metrics,
 	}
-	log.Println("call convertCopy: ", debugString(b), " ---> ", debugString(m))
+	log.Println("call render: ", debugString(results), " ---> ", debugString(m))
 	_, err := expfmt.MetricFamilyToText(writer, m)
 	return err
 }
 
+// seriesAppender returns the scraper's configured SeriesAppender,
+// falling back to the in-process defaultAppender which renders points
+// onto the same expfmt text path as Number results.
+func (s *bosunScraper) seriesAppender() SeriesAppender {
+	if s.appender != nil {
+		return s.appender
+	}
+	return s.defaultAppender
+}
+
 func debugString(v interface{}) string {
 	data, _ := json.Marshal(v)
 	return string(data)
 }
 
-func convertBosunResponse2Metrics(b *BosunResponse) (ret []*dto.Metric) {
-	for _, r := range b.Results {
-		if r.Value.Number != nil {
-			ret = append(ret, &dto.Metric{
-				Label: convertTagSet2Labels(r.Group),
-				Untyped: &dto.Untyped{
-					Value: r.Value.Number,
-				},
-			})
-		} else if r.Value.Series != nil {
-			for k, v := range *r.Value.Series {
-				tk, tv := k, v
-				ret = append(ret, &dto.Metric{
-					Label: convertTagSet2Labels(r.Group),
-					Untyped: &dto.Untyped{
-						Value: &tv,
-					},
-					TimestampMs: &tk,
-				})
-			}
-		}
+func seriesSamples(series Series) []Sample {
+	out := make([]Sample, 0, len(series))
+	for t, v := range series {
+		out = append(out, Sample{T: t, V: v})
 	}
-	return
+	return out
+}
+
+// familyType maps a BosunMetricType to the dto.MetricType emitted for
+// the whole metric family.
+func familyType(mt BosunMetricType) dto.MetricType {
+	switch mt {
+	case BosunMetricGauge:
+		return dto.MetricType_GAUGE
+	case BosunMetricCounter:
+		return dto.MetricType_COUNTER
+	default:
+		return dto.MetricType_UNTYPED
+	}
+}
+
+// newBosunMetric builds a dto.Metric of the type described by mt. ts is
+// nil for Number results, which carry no Bosun timestamp of their own.
+func newBosunMetric(mt BosunMetricType, labels []*dto.LabelPair, value float64, ts *int64) *dto.Metric {
+	m := &dto.Metric{Label: labels, TimestampMs: ts}
+	switch mt {
+	case BosunMetricGauge:
+		m.Gauge = &dto.Gauge{Value: &value}
+	case BosunMetricCounter:
+		m.Counter = &dto.Counter{Value: &value}
+	default:
+		m.Untyped = &dto.Untyped{Value: &value}
+	}
+	return m
 }
 
-func convertTagSet2Labels(set TagSet) (ret []*dto.LabelPair) {
+func convertTagSet2Labels(set TagSet, cfg *BosunConfig) (ret []*dto.LabelPair) {
 	for k, v := range set {
-		tk, tv := k, v
+		tk, tv := cfg.labelName(k), v
 		ret = append(ret, &dto.LabelPair{
 			Name:  &tk,
 			Value: &tv,
@@ -0,0 +1,179 @@
+package scrape
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// staleNaN is the bit pattern Prometheus uses internally to mark a
+// sample as stale (pkg/value.StaleNaN in the main tree). Emitting it
+// lets a disappearing Bosun series be treated the same way a
+// disappearing scrape target is.
+var staleNaN = math.Float64frombits(0x7ff0000000000002)
+
+// Sample is one (timestamp, value) point of a Bosun Series result.
+type Sample struct {
+	T int64 // milliseconds, as returned by Bosun
+	V float64
+}
+
+// SeriesAppender is the write path a Series result is pushed through:
+// each Result's TagSet and its samples are appended with their original
+// Bosun timestamps, rather than collapsed to a single value at scrape
+// time.
+type SeriesAppender interface {
+	AppendSeries(group TagSet, samples []Sample) error
+}
+
+// seriesState tracks, per bosunScraper, the dedup high-water mark and
+// last-seen TagSets needed to drop already-ingested points and detect
+// series that disappeared between scrapes.
+type seriesState struct {
+	mu       sync.Mutex
+	lastTs   map[string]int64
+	lastSeen map[string]TagSet
+	ingested map[string]map[int64]bool // kept timestamps above the tolerance floor
+}
+
+func newSeriesState() *seriesState {
+	return &seriesState{
+		lastTs:   map[string]int64{},
+		lastSeen: map[string]TagSet{},
+		ingested: map[string]map[int64]bool{},
+	}
+}
+
+// dedup drops points already ingested for this series - anything at or
+// before the high-water mark minus outOfOrderTolerance, and any
+// timestamp already kept inside that tolerance window - and points
+// older than maxLookback (if set), then advances the high-water mark to
+// the newest point kept.
+func (s *seriesState) dedup(key string, samples []Sample, maxLookback, outOfOrderTolerance time.Duration, now time.Time) []Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last := s.lastTs[key]
+	floor := last - outOfOrderTolerance.Milliseconds()
+	seen := s.ingested[key]
+	var cutoff int64
+	if maxLookback > 0 {
+		cutoff = now.Add(-maxLookback).UnixNano() / int64(time.Millisecond)
+	}
+
+	out := make([]Sample, 0, len(samples))
+	for _, sm := range samples {
+		if sm.T <= floor || seen[sm.T] {
+			continue
+		}
+		if cutoff > 0 && sm.T < cutoff {
+			continue
+		}
+		out = append(out, sm)
+	}
+	for _, sm := range out {
+		if sm.T > last {
+			last = sm.T
+		}
+	}
+	s.lastTs[key] = last
+	floor = last - outOfOrderTolerance.Milliseconds()
+	window := map[int64]bool{}
+	for t := range seen {
+		if t > floor {
+			window[t] = true
+		}
+	}
+	for _, sm := range out {
+		if sm.T > floor {
+			window[sm.T] = true
+		}
+	}
+	s.ingested[key] = window
+	return out
+}
+
+// observe records which series were present in this scrape and returns
+// the TagSets of series that were present last scrape but not this one.
+func (s *seriesState) observe(seenNow map[string]TagSet) []TagSet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var missing []TagSet
+	for key, group := range s.lastSeen {
+		if _, ok := seenNow[key]; !ok {
+			missing = append(missing, group)
+		}
+	}
+	s.lastSeen = seenNow
+	return missing
+}
+
+// metricFamilyAppender is the default SeriesAppender used when a
+// bosunScraper has no storage-backed appender wired up: it buffers
+// points as dto.Metrics so render can fold them into the same
+// expfmt text family as Number results.
+type metricFamilyAppender struct {
+	cfg     *BosunConfig
+	metrics []*dto.Metric
+}
+
+func newMetricFamilyAppender(cfg *BosunConfig) *metricFamilyAppender {
+	return &metricFamilyAppender{cfg: cfg}
+}
+
+func (a *metricFamilyAppender) AppendSeries(group TagSet, samples []Sample) error {
+	labels := convertTagSet2Labels(group, a.cfg)
+	for _, sm := range samples {
+		ts := sm.T
+		a.metrics = append(a.metrics, newBosunMetric(a.cfg.MetricType, labels, sm.V, &ts))
+	}
+	return nil
+}
+
+// drain returns the metrics buffered since the last drain and resets
+// the buffer for the next scrape.
+func (a *metricFamilyAppender) drain() []*dto.Metric {
+	metrics := a.metrics
+	a.metrics = nil
+	return metrics
+}
+
+// memSeriesAppender is a storage-backed SeriesAppender stand-in: it
+// keeps every ingested sample per series in memory, keyed by the
+// series' TagSet, instead of collapsing them into a single
+// dto.MetricFamily. BosunManager wires one of these into every
+// bosunScraper it creates, so a job's Series results are retained as
+// genuinely distinct timestamped samples and can be read back with
+// Samples.
+type memSeriesAppender struct {
+	mu    sync.Mutex
+	byKey map[string][]Sample
+}
+
+func newMemSeriesAppender() *memSeriesAppender {
+	return &memSeriesAppender{byKey: map[string][]Sample{}}
+}
+
+func (a *memSeriesAppender) AppendSeries(group TagSet, samples []Sample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := group.Tags()
+	a.byKey[key] = append(a.byKey[key], samples...)
+	return nil
+}
+
+// Samples returns the samples appended so far for the series matching
+// group, in append order.
+func (a *memSeriesAppender) Samples(group TagSet) []Sample {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]Sample, len(a.byKey[group.Tags()]))
+	copy(out, a.byKey[group.Tags()])
+	return out
+}
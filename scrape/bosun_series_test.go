@@ -0,0 +1,100 @@
+package scrape
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeriesStateDedup(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := newSeriesState()
+
+	first := s.dedup("host=a", []Sample{{T: 100, V: 1}, {T: 200, V: 2}}, 0, 0, now)
+	if len(first) != 2 {
+		t.Fatalf("first dedup: want 2 samples, got %d", len(first))
+	}
+
+	second := s.dedup("host=a", []Sample{{T: 100, V: 1}, {T: 200, V: 2}, {T: 300, V: 3}}, 0, 0, now)
+	if len(second) != 1 || second[0].T != 300 {
+		t.Fatalf("second dedup: want only T=300, got %+v", second)
+	}
+}
+
+func TestSeriesStateDedupOutOfOrderTolerance(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := newSeriesState()
+
+	s.dedup("host=a", []Sample{{T: 1000, V: 1}}, 0, 0, now)
+
+	// A point 500ms before the high-water mark is still kept within a
+	// 1s out-of-order tolerance.
+	kept := s.dedup("host=a", []Sample{{T: 500, V: 2}}, 0, time.Second, now)
+	if len(kept) != 1 {
+		t.Fatalf("want the out-of-order point kept, got %+v", kept)
+	}
+
+	// The same point replayed again was already ingested, so it is
+	// dropped even though it still lies inside the tolerance window.
+	dropped := s.dedup("host=a", []Sample{{T: 500, V: 2}}, 0, time.Second, now)
+	if len(dropped) != 0 {
+		t.Fatalf("want the replayed point dropped, got %+v", dropped)
+	}
+}
+
+func TestSeriesStateDedupMaxLookback(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := newSeriesState()
+
+	cutoff := now.Add(-time.Minute).UnixNano() / int64(time.Millisecond)
+	samples := []Sample{
+		{T: cutoff - 1, V: 1}, // older than max lookback, dropped
+		{T: cutoff + 1, V: 2}, // within lookback, kept
+	}
+	kept := s.dedup("host=a", samples, time.Minute, 0, now)
+	if len(kept) != 1 || kept[0].T != cutoff+1 {
+		t.Fatalf("want only the in-window sample, got %+v", kept)
+	}
+}
+
+func TestSeriesStateObserve(t *testing.T) {
+	s := newSeriesState()
+	a := TagSet{"host": "a"}
+	b := TagSet{"host": "b"}
+
+	if missing := s.observe(map[string]TagSet{a.Tags(): a, b.Tags(): b}); len(missing) != 0 {
+		t.Fatalf("first observe: want no missing series, got %+v", missing)
+	}
+
+	missing := s.observe(map[string]TagSet{a.Tags(): a})
+	if len(missing) != 1 || !missing[0].Equal(b) {
+		t.Fatalf("want host=b reported missing, got %+v", missing)
+	}
+
+	// A third observe with the same set as last time reports nothing
+	// missing again.
+	if missing := s.observe(map[string]TagSet{a.Tags(): a}); len(missing) != 0 {
+		t.Fatalf("want no missing series once host=b has dropped out, got %+v", missing)
+	}
+}
+
+func TestMemSeriesAppender(t *testing.T) {
+	a := newMemSeriesAppender()
+	group := TagSet{"host": "a"}
+
+	if err := a.AppendSeries(group, []Sample{{T: 1, V: 1}}); err != nil {
+		t.Fatalf("AppendSeries: %v", err)
+	}
+	if err := a.AppendSeries(group, []Sample{{T: 2, V: 2}}); err != nil {
+		t.Fatalf("AppendSeries: %v", err)
+	}
+
+	got := a.Samples(group)
+	want := []Sample{{T: 1, V: 1}, {T: 2, V: 2}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("Samples() = %+v, want %+v", got, want)
+	}
+
+	if got := a.Samples(TagSet{"host": "b"}); len(got) != 0 {
+		t.Fatalf("Samples() for unseen group: want empty, got %+v", got)
+	}
+}
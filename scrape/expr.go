@@ -0,0 +1,605 @@
+package scrape
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// Results is the output of evaluating an Expr: a set of Results grouped
+// by TagSet, same shape as BosunResponse.Results.
+type Results []*Result
+
+// Backend executes the OpenTSDB queries produced by a q(...) leaf of an
+// expression tree. bosunScraper's own implementation (see
+// bosun_backend.go) replays the query against the target's configured
+// Bosun endpoint.
+type Backend interface {
+	Query(ctx context.Context, q *Query, start, end string) (Results, error)
+}
+
+// Expr is a parsed Bosun-style expression tree. Parse builds one from
+// text; Eval walks it against a Backend to produce Results.
+type Expr interface {
+	Eval(ctx context.Context, backend Backend) (Results, error)
+}
+
+// Parse parses a small Bosun-style expression language: scalar
+// arithmetic (+ - * /), comparisons (> < >= <= == !=) that yield
+// alertable 0/1 numbers, and the functions q(query, start, end),
+// merge(e...), filter(e, "tagk=value,..."), group_by(e, "tag,..."), and
+// rename(e, "tagk", "tagk'").
+func Parse(s string) (Expr, error) {
+	p := &exprParser{lex: newExprLexer(s)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	e, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != exprTokEOF {
+		return nil, errors.Errorf("expr: unexpected trailing input %q", p.tok.text)
+	}
+	return e, nil
+}
+
+// scalarExpr is a bare numeric literal, represented as a single Result
+// with an empty Group so it broadcasts against any group in a binary
+// expression.
+type scalarExpr struct{ v float64 }
+
+func (e *scalarExpr) Eval(ctx context.Context, backend Backend) (Results, error) {
+	v := e.v
+	return Results{{Group: TagSet{}, Value: ResultValue{Number: &v}}}, nil
+}
+
+// queryExpr is a q("...opentsdb query...", "start", "end") leaf.
+type queryExpr struct {
+	query      *Query
+	start, end string
+}
+
+func (e *queryExpr) Eval(ctx context.Context, backend Backend) (Results, error) {
+	return backend.Query(ctx, e.query, e.start, e.end)
+}
+
+// mergeExpr concatenates the Results of every argument.
+type mergeExpr struct{ args []Expr }
+
+func (e *mergeExpr) Eval(ctx context.Context, backend Backend) (Results, error) {
+	var out Results
+	for _, arg := range e.args {
+		res, err := arg.Eval(ctx, backend)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, res...)
+	}
+	return out, nil
+}
+
+// filterExpr keeps only Results whose Group matches every filter in
+// rawFilters, parsed with the same ParseFilters used for OpenTSDB
+// queries.
+type filterExpr struct {
+	src        Expr
+	rawFilters string
+}
+
+func (e *filterExpr) Eval(ctx context.Context, backend Backend) (Results, error) {
+	res, err := e.src.Eval(ctx, backend)
+	if err != nil {
+		return nil, err
+	}
+	filters, err := ParseFilters(e.rawFilters, false, &Query{})
+	if err != nil {
+		return nil, err
+	}
+	var out Results
+	for _, r := range res {
+		if matchesFilters(r.Group, filters) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func matchesFilters(group TagSet, filters []Filter) bool {
+	for _, f := range filters {
+		v, ok := group[f.TagK]
+		if !ok {
+			return false
+		}
+		switch f.Type {
+		case "wildcard", "iwildcard":
+			if f.Filter != "*" && !strings.Contains(v, strings.Trim(f.Filter, "*")) {
+				return false
+			}
+		default: // literal_or and anything else: pipe-separated exact match
+			match := false
+			for _, want := range strings.Split(f.Filter, "|") {
+				if v == want {
+					match = true
+					break
+				}
+			}
+			if !match {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// groupByExpr re-groups Results down to only the listed tag keys,
+// summing Number values that collapse into the same reduced group.
+type groupByExpr struct {
+	src  Expr
+	tags []string
+}
+
+func (e *groupByExpr) Eval(ctx context.Context, backend Backend) (Results, error) {
+	res, err := e.src.Eval(ctx, backend)
+	if err != nil {
+		return nil, err
+	}
+	sums := map[string]*Result{}
+	var order []string
+	for _, r := range res {
+		reduced := TagSet{}
+		for _, tag := range e.tags {
+			if v, ok := r.Group[tag]; ok {
+				reduced[tag] = v
+			}
+		}
+		key := reduced.Tags()
+		if existing, ok := sums[key]; ok {
+			if existing.Value.Number != nil && r.Value.Number != nil {
+				sum := *existing.Value.Number + *r.Value.Number
+				existing.Value.Number = &sum
+			}
+			continue
+		}
+		sums[key] = &Result{Group: reduced, Value: r.Value}
+		order = append(order, key)
+	}
+	out := make(Results, 0, len(order))
+	for _, key := range order {
+		out = append(out, sums[key])
+	}
+	return out, nil
+}
+
+// renameExpr renames tag key `from` to `to` in every Result's Group.
+type renameExpr struct {
+	src      Expr
+	from, to string
+}
+
+func (e *renameExpr) Eval(ctx context.Context, backend Backend) (Results, error) {
+	res, err := e.src.Eval(ctx, backend)
+	if err != nil {
+		return nil, err
+	}
+	out := make(Results, 0, len(res))
+	for _, r := range res {
+		group := r.Group.Copy()
+		if v, ok := group[e.from]; ok {
+			delete(group, e.from)
+			group[e.to] = v
+		}
+		out = append(out, &Result{Group: group, Value: r.Value})
+	}
+	return out, nil
+}
+
+// binaryExpr applies a scalar arithmetic or comparison operator across
+// two Results sets, joining on compatible groups. Comparisons produce
+// 1 (true) or 0 (false) as their Number.
+type binaryExpr struct {
+	op       string
+	lhs, rhs Expr
+}
+
+func (e *binaryExpr) Eval(ctx context.Context, backend Backend) (Results, error) {
+	lhs, err := e.lhs.Eval(ctx, backend)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := e.rhs.Eval(ctx, backend)
+	if err != nil {
+		return nil, err
+	}
+
+	var out Results
+	for _, l := range lhs {
+		for _, r := range rhs {
+			if l.Value.Number == nil || r.Value.Number == nil {
+				continue
+			}
+			if len(l.Group) > 0 && len(r.Group) > 0 && !l.Group.Compatible(r.Group) {
+				continue
+			}
+			group := l.Group.Copy().Merge(r.Group)
+			v, err := applyOp(e.op, *l.Value.Number, *r.Value.Number)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, &Result{Group: group, Value: ResultValue{Number: &v}})
+		}
+	}
+	return out, nil
+}
+
+func applyOp(op string, a, b float64) (float64, error) {
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		return a / b, nil
+	case ">":
+		return boolToFloat(a > b), nil
+	case "<":
+		return boolToFloat(a < b), nil
+	case ">=":
+		return boolToFloat(a >= b), nil
+	case "<=":
+		return boolToFloat(a <= b), nil
+	case "==":
+		return boolToFloat(a == b), nil
+	case "!=":
+		return boolToFloat(a != b), nil
+	default:
+		return 0, errors.Errorf("expr: unknown operator %q", op)
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// --- lexer ---
+
+type exprTokenKind int
+
+const (
+	exprTokEOF exprTokenKind = iota
+	exprTokIdent
+	exprTokNumber
+	exprTokString
+	exprTokOp
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string
+}
+
+type exprLexer struct {
+	input []rune
+	pos   int
+}
+
+func newExprLexer(s string) *exprLexer { return &exprLexer{input: []rune(s)} }
+
+func (l *exprLexer) next() (exprToken, error) {
+	for l.pos < len(l.input) && unicode.IsSpace(l.input[l.pos]) {
+		l.pos++
+	}
+	if l.pos >= len(l.input) {
+		return exprToken{kind: exprTokEOF}, nil
+	}
+	c := l.input[l.pos]
+	switch {
+	case c == '"':
+		return l.lexString()
+	case unicode.IsDigit(c):
+		return l.lexNumber()
+	case unicode.IsLetter(c) || c == '_':
+		return l.lexIdent()
+	case strings.ContainsRune("()+-*/,", c):
+		l.pos++
+		return exprToken{kind: exprTokOp, text: string(c)}, nil
+	case strings.ContainsRune(">=<!", c):
+		return l.lexCompareOp()
+	default:
+		return exprToken{}, errors.Errorf("expr: unexpected character %q at offset %d", c, l.pos)
+	}
+}
+
+func (l *exprLexer) lexString() (exprToken, error) {
+	start := l.pos
+	l.pos++ // opening quote
+	var b strings.Builder
+	for l.pos < len(l.input) && l.input[l.pos] != '"' {
+		if l.input[l.pos] == '\\' && l.pos+1 < len(l.input) {
+			l.pos++
+		}
+		b.WriteRune(l.input[l.pos])
+		l.pos++
+	}
+	if l.pos >= len(l.input) {
+		return exprToken{}, errors.Errorf("expr: unterminated string starting at offset %d", start)
+	}
+	l.pos++ // closing quote
+	return exprToken{kind: exprTokString, text: b.String()}, nil
+}
+
+func (l *exprLexer) lexNumber() (exprToken, error) {
+	start := l.pos
+	for l.pos < len(l.input) && (unicode.IsDigit(l.input[l.pos]) || l.input[l.pos] == '.') {
+		l.pos++
+	}
+	return exprToken{kind: exprTokNumber, text: string(l.input[start:l.pos])}, nil
+}
+
+func (l *exprLexer) lexIdent() (exprToken, error) {
+	start := l.pos
+	for l.pos < len(l.input) && (unicode.IsLetter(l.input[l.pos]) || unicode.IsDigit(l.input[l.pos]) || l.input[l.pos] == '_') {
+		l.pos++
+	}
+	return exprToken{kind: exprTokIdent, text: string(l.input[start:l.pos])}, nil
+}
+
+func (l *exprLexer) lexCompareOp() (exprToken, error) {
+	c := l.input[l.pos]
+	l.pos++
+	if l.pos < len(l.input) && l.input[l.pos] == '=' {
+		l.pos++
+		return exprToken{kind: exprTokOp, text: string(c) + "="}, nil
+	}
+	if c == '!' {
+		return exprToken{}, errors.Errorf("expr: expected \"!=\" at offset %d", l.pos-1)
+	}
+	return exprToken{kind: exprTokOp, text: string(c)}, nil
+}
+
+// --- recursive-descent parser ---
+
+type exprParser struct {
+	lex *exprLexer
+	tok exprToken
+}
+
+func (p *exprParser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *exprParser) parseComparison() (Expr, error) {
+	lhs, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == exprTokOp && isComparisonOp(p.tok.text) {
+		op := p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		rhs, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &binaryExpr{op: op, lhs: lhs, rhs: rhs}
+	}
+	return lhs, nil
+}
+
+func isComparisonOp(op string) bool {
+	switch op {
+	case ">", "<", ">=", "<=", "==", "!=":
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseAdditive() (Expr, error) {
+	lhs, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == exprTokOp && (p.tok.text == "+" || p.tok.text == "-") {
+		op := p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		rhs, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &binaryExpr{op: op, lhs: lhs, rhs: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *exprParser) parseMultiplicative() (Expr, error) {
+	lhs, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == exprTokOp && (p.tok.text == "*" || p.tok.text == "/") {
+		op := p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		rhs, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &binaryExpr{op: op, lhs: lhs, rhs: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *exprParser) parsePrimary() (Expr, error) {
+	switch p.tok.kind {
+	case exprTokNumber:
+		v, err := strconv.ParseFloat(p.tok.text, 64)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return &scalarExpr{v: v}, nil
+	case exprTokOp:
+		if p.tok.text == "(" {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			e, err := p.parseComparison()
+			if err != nil {
+				return nil, err
+			}
+			if p.tok.text != ")" {
+				return nil, errors.New("expr: expected \")\"")
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			return e, nil
+		}
+		return nil, errors.Errorf("expr: unexpected token %q", p.tok.text)
+	case exprTokIdent:
+		return p.parseCall()
+	default:
+		return nil, errors.Errorf("expr: unexpected token %q", p.tok.text)
+	}
+}
+
+// callArg is either a sub-expression or a raw string literal, for
+// functions like filter/rename/group_by/q that take both.
+type callArg struct {
+	expr  Expr
+	str   string
+	isStr bool
+}
+
+func (p *exprParser) parseCall() (Expr, error) {
+	name := p.tok.text
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.text != "(" {
+		return nil, errors.Errorf("expr: expected \"(\" after %q", name)
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var args []callArg
+	for p.tok.text != ")" {
+		if p.tok.kind == exprTokString {
+			args = append(args, callArg{str: p.tok.text, isStr: true})
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		} else {
+			e, err := p.parseComparison()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, callArg{expr: e})
+		}
+		if p.tok.text == "," {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		break
+	}
+	if p.tok.text != ")" {
+		return nil, errors.Errorf("expr: expected \")\" to close %q(...)", name)
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	return buildCall(name, args)
+}
+
+func buildCall(name string, args []callArg) (Expr, error) {
+	switch name {
+	case "q":
+		if len(args) != 3 || !args[0].isStr || !args[1].isStr || !args[2].isStr {
+			return nil, errors.New("expr: q(query, start, end) takes three string arguments")
+		}
+		q, err := parseOpenTSDBQuery(args[0].str)
+		if err != nil {
+			return nil, err
+		}
+		return &queryExpr{query: q, start: args[1].str, end: args[2].str}, nil
+	case "merge":
+		exprs := make([]Expr, 0, len(args))
+		for _, a := range args {
+			if a.isStr {
+				return nil, errors.New("expr: merge(...) takes only expression arguments")
+			}
+			exprs = append(exprs, a.expr)
+		}
+		return &mergeExpr{args: exprs}, nil
+	case "filter":
+		if len(args) != 2 || args[0].isStr || !args[1].isStr {
+			return nil, errors.New("expr: filter(expr, \"tagk=value,...\") takes an expression and a string")
+		}
+		return &filterExpr{src: args[0].expr, rawFilters: args[1].str}, nil
+	case "group_by":
+		if len(args) != 2 || args[0].isStr || !args[1].isStr {
+			return nil, errors.New("expr: group_by(expr, \"tag,...\") takes an expression and a string")
+		}
+		return &groupByExpr{src: args[0].expr, tags: strings.Split(args[1].str, ",")}, nil
+	case "rename":
+		if len(args) != 3 || args[0].isStr || !args[1].isStr || !args[2].isStr {
+			return nil, errors.New("expr: rename(expr, \"tagk\", \"tagk'\") takes an expression and two strings")
+		}
+		return &renameExpr{src: args[0].expr, from: args[1].str, to: args[2].str}, nil
+	default:
+		return nil, errors.Errorf("expr: unknown function %q", name)
+	}
+}
+
+// parseOpenTSDBQuery parses a query string of the form
+// "aggregator:metric{tagk=filterFunc(...),...}" into a Query, reusing
+// ParseFilters for the filter portion.
+func parseOpenTSDBQuery(s string) (*Query, error) {
+	q := &Query{GroupByTags: TagSet{}}
+	metricPart := s
+	if i := strings.IndexByte(s, '{'); i >= 0 {
+		if !strings.HasSuffix(s, "}") {
+			return nil, errors.Errorf("expr: malformed query %q: missing closing \"}\"", s)
+		}
+		metricPart = s[:i]
+		rawFilters := s[i+1 : len(s)-1]
+		filters, err := ParseFilters(rawFilters, true, q)
+		if err != nil {
+			return nil, err
+		}
+		q.Filters = filters
+	}
+	parts := strings.Split(metricPart, ":")
+	if len(parts) < 2 {
+		return nil, errors.Errorf("expr: malformed query %q: expected aggregator:metric", s)
+	}
+	q.Aggregator = parts[0]
+	q.Metric = parts[len(parts)-1]
+	if len(parts) == 3 {
+		q.Downsample = parts[1]
+	}
+	return q, nil
+}
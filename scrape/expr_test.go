@@ -0,0 +1,165 @@
+package scrape
+
+import (
+	"context"
+	"testing"
+)
+
+// fakeBackend answers every Query with a fixed Results value, ignoring
+// the query itself, so expr.go's AST nodes can be tested without a real
+// Bosun endpoint.
+type fakeBackend struct {
+	results Results
+}
+
+func (b *fakeBackend) Query(ctx context.Context, q *Query, start, end string) (Results, error) {
+	return b.results, nil
+}
+
+func number(v float64) *Number { return &v }
+
+func evalNumbers(t *testing.T, expr string, backend Backend) []float64 {
+	t.Helper()
+	tree, err := Parse(expr)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", expr, err)
+	}
+	results, err := tree.Eval(context.Background(), backend)
+	if err != nil {
+		t.Fatalf("Eval(%q): %v", expr, err)
+	}
+	var out []float64
+	for _, r := range results {
+		if r.Value.Number == nil {
+			t.Fatalf("Eval(%q): result %+v has no Number", expr, r)
+		}
+		out = append(out, *r.Value.Number)
+	}
+	return out
+}
+
+func TestParseScalarArithmetic(t *testing.T) {
+	cases := map[string]float64{
+		"1 + 2":     3,
+		"10 - 4":    6,
+		"3 * 4":     12,
+		"10 / 4":    2.5,
+		"(1 + 2)*3": 9,
+	}
+	for expr, want := range cases {
+		got := evalNumbers(t, expr, &fakeBackend{})
+		if len(got) != 1 || got[0] != want {
+			t.Errorf("%q = %v, want [%v]", expr, got, want)
+		}
+	}
+}
+
+func TestParseComparisons(t *testing.T) {
+	cases := map[string]float64{
+		"1 > 2":  0,
+		"2 > 1":  1,
+		"2 >= 2": 1,
+		"2 == 2": 1,
+		"2 != 2": 0,
+	}
+	for expr, want := range cases {
+		got := evalNumbers(t, expr, &fakeBackend{})
+		if len(got) != 1 || got[0] != want {
+			t.Errorf("%q = %v, want [%v]", expr, got, want)
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	backend := &fakeBackend{results: Results{
+		{Group: TagSet{"host": "a"}, Value: ResultValue{Number: number(5)}},
+	}}
+	got := evalNumbers(t, `q("avg:some.metric{host=*}", "1h", "")`, backend)
+	if len(got) != 1 || got[0] != 5 {
+		t.Fatalf("q(...) = %v, want [5]", got)
+	}
+}
+
+func TestParseMerge(t *testing.T) {
+	backend := &fakeBackend{results: Results{
+		{Group: TagSet{"host": "a"}, Value: ResultValue{Number: number(1)}},
+	}}
+	tree, err := Parse(`merge(q("avg:m{host=*}", "1h", ""), q("avg:m{host=*}", "1h", ""))`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	results, err := tree.Eval(context.Background(), backend)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("merge(...) produced %d results, want 2", len(results))
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	backend := &fakeBackend{results: Results{
+		{Group: TagSet{"host": "a"}, Value: ResultValue{Number: number(1)}},
+		{Group: TagSet{"host": "b"}, Value: ResultValue{Number: number(2)}},
+	}}
+	tree, err := Parse(`filter(q("avg:m{host=*}", "1h", ""), "host=a")`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	results, err := tree.Eval(context.Background(), backend)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	if len(results) != 1 || !results[0].Group.Equal(TagSet{"host": "a"}) {
+		t.Fatalf("filter(...) = %+v, want only host=a", results)
+	}
+}
+
+func TestParseGroupBySums(t *testing.T) {
+	backend := &fakeBackend{results: Results{
+		{Group: TagSet{"host": "a", "dc": "x"}, Value: ResultValue{Number: number(1)}},
+		{Group: TagSet{"host": "b", "dc": "x"}, Value: ResultValue{Number: number(2)}},
+	}}
+	tree, err := Parse(`group_by(q("avg:m{host=*}", "1h", ""), "dc")`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	results, err := tree.Eval(context.Background(), backend)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	if len(results) != 1 || *results[0].Value.Number != 3 {
+		t.Fatalf("group_by(...) = %+v, want a single summed result of 3", results)
+	}
+}
+
+func TestParseRename(t *testing.T) {
+	backend := &fakeBackend{results: Results{
+		{Group: TagSet{"host": "a"}, Value: ResultValue{Number: number(1)}},
+	}}
+	tree, err := Parse(`rename(q("avg:m{host=*}", "1h", ""), "host", "instance")`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	results, err := tree.Eval(context.Background(), backend)
+	if err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	if len(results) != 1 || !results[0].Group.Equal(TagSet{"instance": "a"}) {
+		t.Fatalf("rename(...) = %+v, want instance=a", results)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []string{
+		"1 +",
+		"unknownfn(1)",
+		`q("nocolon", "1h", "")`,
+		"(1 + 2",
+	}
+	for _, expr := range cases {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q): want error, got nil", expr)
+		}
+	}
+}
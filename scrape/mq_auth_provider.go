@@ -0,0 +1,95 @@
+package scrape
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	mq "code.byted.org/inf/metrics-query"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	RegisterAuthProviderFactory("mq-cluster-token", newMqAuthProvider)
+}
+
+// mqAuthProvider obtains its Authorization header from a metrics-query
+// cluster option, refreshing it on a fixed interval. This is the
+// AuthProvider backend for the "cn" Bosun proxy cluster that
+// bosunScraper used to reach via a package-global.
+type mqAuthProvider struct {
+	opt      *mq.ClusterOption
+	interval time.Duration
+
+	mu        sync.Mutex
+	expiresAt time.Time
+}
+
+// newMqAuthProvider builds an mqAuthProvider from its YAML config block:
+//
+//	cluster: "cn"
+//	tenant: "ad.oe.metrics"
+//	secret: "..."
+//	refresh_interval: 30m
+func newMqAuthProvider(cfg map[string]interface{}) (AuthProvider, error) {
+	cluster, _ := cfg["cluster"].(string)
+	if cluster == "" {
+		return nil, errors.New("mq-cluster-token: \"cluster\" must be set")
+	}
+	tenant, _ := cfg["tenant"].(string)
+	secret, _ := cfg["secret"].(string)
+	if tenant == "" || secret == "" {
+		return nil, errors.New("mq-cluster-token: \"tenant\" and \"secret\" must be set")
+	}
+	interval := 30 * time.Minute
+	if raw, ok := cfg["refresh_interval"]; ok {
+		s, ok := raw.(string)
+		if !ok {
+			return nil, errors.Errorf("mq-cluster-token: \"refresh_interval\" must be a duration string, got %T", raw)
+		}
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "mq-cluster-token: invalid \"refresh_interval\"")
+		}
+		if v > 0 {
+			interval = v
+		}
+	}
+
+	opt := mq.DefaultClusterConfig.Proxy.Get(cluster)
+	if opt == nil {
+		return nil, errors.Errorf("mq-cluster-token: unknown cluster %q", cluster)
+	}
+	opt.SetTenant(tenant, secret)
+
+	p := &mqAuthProvider{opt: opt, interval: interval}
+	if err := p.Refresh(context.Background()); err != nil {
+		return nil, err
+	}
+	go p.refreshForever()
+	return p, nil
+}
+
+func (p *mqAuthProvider) refreshForever() {
+	for range time.Tick(p.interval) {
+		_ = p.Refresh(context.Background())
+	}
+}
+
+func (p *mqAuthProvider) Refresh(ctx context.Context) error {
+	_, err := p.opt.RefreshToken(true, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.expiresAt = time.Now().Add(p.interval)
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *mqAuthProvider) AccessToken(ctx context.Context) (string, time.Time, error) {
+	p.mu.Lock()
+	expiresAt := p.expiresAt
+	p.mu.Unlock()
+	return p.opt.AccessToken(), expiresAt, nil
+}